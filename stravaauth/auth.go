@@ -2,7 +2,9 @@ package stravaauth
 
 import (
 	"context"
-	"encoding/gob"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"flag"
 	"fmt"
@@ -16,6 +18,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -30,6 +33,12 @@ var (
 		"Name of a file containing just the project's OAuth 2.0 Client Secret.")
 	cacheToken = flag.Bool("strava-cachetoken", true, "cache the OAuth 2.0 token")
 
+	authModeFlag = flag.String("strava-auth-mode", "browser",
+		"How to perform the OAuth 2.0 handshake: browser, manual, or loopback. "+
+			"Use manual or loopback when xdg-open and ephemeral local ports aren't usable, e.g. over SSH or in a container.")
+	loopbackPort = flag.Int("strava-loopback-port", 8080,
+		"Fixed loopback port to bind for --strava-auth-mode=loopback. Must match a redirect URI registered with your Strava app.")
+
 	tokenFilePrefix = "strava-auth-tok"
 )
 
@@ -40,13 +49,44 @@ var (
 // The oauth2ContextType should be "strava.ContextOAuth2", using your Swagger-generated "strava" package.
 // Having this passed in avoids this stravaauth package from needing to depend on the Swagger-generated
 // Strava API code directly.
-func GetOAuth2Ctx(parentCtx context.Context, oauth2ContextType fmt.Stringer, scopes []string) (context.Context, error) {
+func GetOAuth2Ctx(parentCtx context.Context, oauth2ContextType fmt.Stringer, scopes []string, opts ...Option) (context.Context, error) {
 	if !flag.Parsed() {
-		return nil, errors.New("Must call Flag.Parse() before GetOAuth2Ctx()")
+		return nil, errOAuth2NotParsed
 	}
 	if !strings.Contains(oauth2ContextType.String(), "token") {
 		return nil, errors.New("You must call GetOAuth2Ctx with oauth2ContextType set to strava.ContextOAuth2")
 	}
+	tokSource, err := LoadTokenSource(parentCtx, scopes, opts...)
+	if err != nil {
+		return nil, err
+	}
+	oauthCtx := context.WithValue(parentCtx, oauth2ContextType, tokSource)
+	return oauthCtx, nil
+}
+
+// errOAuth2NotParsed is returned by GetOAuth2Ctx, LoadTokenSource, and GetOAuth2Client when
+// called before flag.Parse().
+var errOAuth2NotParsed = errors.New("Must call Flag.Parse() before using stravaauth")
+
+// LoadTokenSource returns an oauth2.TokenSource authorized for the given scopes, performing the
+// browser-based Authorization Code + PKCE handshake if no cached token is available yet. The
+// returned TokenSource persists refreshed tokens (including the refresh token) back to its
+// TokenStore as they're renewed, so a long-running daemon can keep calling Strava indefinitely
+// without ever re-prompting the user after the initial login. By default tokens are cached in a
+// gob file in the OS user cache dir; pass WithTokenStore to use a different backend.
+func LoadTokenSource(parentCtx context.Context, scopes []string, opts ...Option) (oauth2.TokenSource, error) {
+	if !flag.Parsed() {
+		return nil, errOAuth2NotParsed
+	}
+	o := resolveOptions(opts)
+	config, tok, cacheKey := configAndToken(parentCtx, scopes, o)
+	return newPersistentTokenSource(config.TokenSource(parentCtx, tok), o.store, cacheKey, tok), nil
+}
+
+// configAndToken builds the oauth2.Config for scopes and returns it along with a valid token
+// (from cache, or from a fresh browser login) and the key it's cached under. It's the shared core
+// of LoadTokenSource and GetOAuth2Client.
+func configAndToken(parentCtx context.Context, scopes []string, o *options) (*oauth2.Config, *oauth2.Token, string) {
 	config := &oauth2.Config{
 		ClientID:     valueOrFileContents(*clientID, *clientIDFile),
 		ClientSecret: valueOrFileContents(*secret, *secretFile),
@@ -57,10 +97,18 @@ func GetOAuth2Ctx(parentCtx context.Context, oauth2ContextType fmt.Stringer, sco
 		// Strava expects one string of comma-separated scopes.
 		Scopes: []string{strings.Join(scopes, ",")},
 	}
-	tok := getOAuthToken(parentCtx, config)
-	tokSource := config.TokenSource(parentCtx, tok)
-	oauthCtx := context.WithValue(parentCtx, oauth2ContextType, tokSource)
-	return oauthCtx, nil
+	cacheKey := tokenCacheKey(config, o.userKey)
+	tok, cacheKey := getOAuthToken(parentCtx, config, o, cacheKey)
+	return config, tok, cacheKey
+}
+
+// ClientCredentials returns the configured OAuth 2.0 client ID and secret, the same values used
+// to build the oauth2.Config in LoadTokenSource. Other packages in this module that need to call
+// Strava APIs authenticated with app-level credentials rather than an athlete's token (e.g.
+// stravaauth/webhooks) can use this instead of re-declaring the --strava-clientid /
+// --strava-secret flags themselves.
+func ClientCredentials() (id, pass string) {
+	return valueOrFileContents(*clientID, *clientIDFile), valueOrFileContents(*secret, *secretFile)
 }
 
 func osUserCacheDir() string {
@@ -75,60 +123,142 @@ func osUserCacheDir() string {
 	return subDir
 }
 
-func tokenCacheFile(config *oauth2.Config) string {
+// tokenCacheKey derives the TokenStore key for config: a hash of the client ID, secret, scopes,
+// and userKey, so distinct apps, scope sets, or (for multi-athlete setups) distinct users never
+// collide in the same store. userKey is empty for the single-user case.
+func tokenCacheKey(config *oauth2.Config, userKey string) string {
 	hash := fnv.New32a()
 	hash.Write([]byte(config.ClientID))
 	hash.Write([]byte(config.ClientSecret))
 	hash.Write([]byte(strings.Join(config.Scopes, " ")))
+	hash.Write([]byte(userKey))
 	fn := fmt.Sprintf("%s%v", tokenFilePrefix, hash.Sum32())
-	return filepath.Join(osUserCacheDir(), url.QueryEscape(fn))
+	return url.QueryEscape(fn)
 }
 
-func tokenFromFile(file string) (*oauth2.Token, error) {
-	if !*cacheToken {
-		return nil, errors.New("--cachetoken is false")
+// getOAuthToken returns a token for cacheKey, obtaining one via the browser if none is cached
+// yet. If o.deriveUser is set and o.userKey isn't, a freshly-obtained token is re-keyed under the
+// key deriveUser computes from it (e.g. the athlete ID), and the updated key is returned alongside
+// the token so the caller persists and refreshes it under that key from then on.
+func getOAuthToken(ctx context.Context, config *oauth2.Config, o *options, cacheKey string) (*oauth2.Token, string) {
+	token, err := o.store.Get(cacheKey)
+	if err == nil {
+		log.Printf("Using cached token %#v from key %q", token, cacheKey)
+		return token, cacheKey
+	}
+	token = tokenFromWeb(ctx, config)
+	if o.userKey == "" && o.deriveUser != nil {
+		if derived := o.deriveUser(token); derived != "" {
+			cacheKey = tokenCacheKey(config, derived)
+		}
+	}
+	if err := o.store.Put(cacheKey, token); err != nil {
+		log.Printf("Warning: failed to cache oauth token: %v", err)
 	}
-	f, err := os.Open(file)
+	log.Printf("Saved new token %#v under key %q", token, cacheKey)
+	return token, cacheKey
+}
+
+// persistentTokenSource wraps an oauth2.TokenSource and re-saves the token to its TokenStore
+// whenever the wrapped source returns a token different from the last one it handed out, so
+// refresh tokens minted during a refresh are never lost even if the process is killed before
+// exiting cleanly. Token is safe for concurrent use, as required by oauth2.TokenSource.
+type persistentTokenSource struct {
+	base     oauth2.TokenSource
+	store    TokenStore
+	cacheKey string
+
+	mu   sync.Mutex
+	last *oauth2.Token
+}
+
+func newPersistentTokenSource(base oauth2.TokenSource, store TokenStore, cacheKey string, initial *oauth2.Token) oauth2.TokenSource {
+	return &persistentTokenSource{base: base, store: store, cacheKey: cacheKey, last: initial}
+}
+
+func (p *persistentTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := p.base.Token()
 	if err != nil {
 		return nil, err
 	}
-	t := new(oauth2.Token)
-	err = gob.NewDecoder(f).Decode(t)
-	return t, err
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.last == nil || tok.AccessToken != p.last.AccessToken || tok.RefreshToken != p.last.RefreshToken {
+		if err := p.store.Put(p.cacheKey, tok); err != nil {
+			log.Printf("Warning: failed to cache refreshed oauth token: %v", err)
+		} else {
+			log.Printf("Refreshed and saved token %#v under key %q", tok, p.cacheKey)
+		}
+		p.last = tok
+	}
+	return tok, nil
 }
 
-func saveToken(file string, token *oauth2.Token) {
-	f, err := os.Create(file)
+// tokenFromWeb runs the interactive Authorization Code + PKCE handshake, using whichever
+// AuthMode was selected via --strava-auth-mode.
+func tokenFromWeb(ctx context.Context, config *oauth2.Config) *oauth2.Token {
+	mode, err := parseAuthMode(*authModeFlag)
 	if err != nil {
-		log.Printf("Warning: failed to cache oauth token: %v", err)
-		return
+		log.Fatalf("%v", err)
 	}
-	defer f.Close()
-	gob.NewEncoder(f).Encode(token)
+	switch mode {
+	case AuthModeManual:
+		return tokenFromWebManual(ctx, config)
+	case AuthModeLoopback:
+		return tokenFromWebLoopback(ctx, config)
+	default:
+		return tokenFromWebBrowser(ctx, config)
+	}
+}
+
+// authCodeRequest bundles the state and PKCE parameters shared by every AuthMode's authorization
+// code handshake, so each mode only needs to supply how it gets the code back (a local HTTP
+// callback, or a code pasted from stdin).
+type authCodeRequest struct {
+	state     string
+	verifier  string
+	challenge string
 }
 
-func getOAuthToken(ctx context.Context, config *oauth2.Config) *oauth2.Token {
-	cacheFile := tokenCacheFile(config)
-	token, err := tokenFromFile(cacheFile)
+// newAuthCodeRequest generates a fresh state value and PKCE verifier/challenge pair, as described
+// in https://datatracker.ietf.org/doc/html/rfc7636#section-4.1 and #section-4.2.
+func newAuthCodeRequest() authCodeRequest {
+	verifier, err := newCodeVerifier()
 	if err != nil {
-		token = tokenFromWeb(ctx, config)
-		saveToken(cacheFile, token)
-		log.Printf("Saved new token %#v to %q", token, cacheFile)
-	} else {
-		log.Printf("Using cached token %#v from %q", token, cacheFile)
+		log.Fatalf("Error generating PKCE code verifier: %v", err)
+	}
+	return authCodeRequest{
+		state:     fmt.Sprintf("st%d", time.Now().UnixNano()),
+		verifier:  verifier,
+		challenge: codeChallengeS256(verifier),
+	}
+}
+
+func (a authCodeRequest) authURL(config *oauth2.Config) string {
+	return config.AuthCodeURL(a.state,
+		oauth2.SetAuthURLParam("code_challenge", a.challenge),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+}
+
+// exchange trades code for a token, passing along the PKCE code_verifier that must match the
+// code_challenge sent to authURL.
+func (a authCodeRequest) exchange(ctx context.Context, config *oauth2.Config, code string) *oauth2.Token {
+	token, err := config.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", a.verifier))
+	if err != nil {
+		log.Fatalf("Token exchange error: %v", err)
 	}
 	return token
 }
 
-func tokenFromWeb(ctx context.Context, config *oauth2.Config) *oauth2.Token {
-	ch := make(chan string)
-	randState := fmt.Sprintf("st%d", time.Now().UnixNano())
-	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+// callbackHandler returns the HTTP handler shared by AuthMode=browser and AuthMode=loopback: it
+// validates the state Strava echoes back, then pushes the authorization code to ch.
+func callbackHandler(state string, ch chan<- string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		if req.URL.Path == "/favicon.ico" {
 			http.Error(rw, "", 404)
 			return
 		}
-		if req.FormValue("state") != randState {
+		if req.FormValue("state") != state {
 			log.Printf("State doesn't match: req = %#v", req)
 			http.Error(rw, "", 500)
 			return
@@ -141,21 +271,41 @@ func tokenFromWeb(ctx context.Context, config *oauth2.Config) *oauth2.Token {
 		}
 		log.Printf("no code")
 		http.Error(rw, "", 500)
-	}))
+	})
+}
+
+func tokenFromWebBrowser(ctx context.Context, config *oauth2.Config) *oauth2.Token {
+	req := newAuthCodeRequest()
+	ch := make(chan string)
+	ts := httptest.NewServer(callbackHandler(req.state, ch))
 	defer ts.Close()
 
 	config.RedirectURL = ts.URL
-	authURL := config.AuthCodeURL(randState)
+	authURL := req.authURL(config)
 	go openURL(authURL)
 	log.Printf("Authorize this app at: %s", authURL)
 	code := <-ch
 	log.Printf("Got code: %s", code)
 
-	token, err := config.Exchange(ctx, code)
-	if err != nil {
-		log.Fatalf("Token exchange error: %v", err)
+	return req.exchange(ctx, config, code)
+}
+
+// newCodeVerifier generates a cryptographically random PKCE code verifier, as described in
+// https://datatracker.ietf.org/doc/html/rfc7636#section-4.1: 32 random bytes, base64url-encoded
+// without padding.
+func newCodeVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
-	return token
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// codeChallengeS256 derives the S256 PKCE code challenge for the given code verifier, per
+// https://datatracker.ietf.org/doc/html/rfc7636#section-4.2.
+func codeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
 }
 
 func openURL(url string) {
@@ -0,0 +1,103 @@
+package stravaauth
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"golang.org/x/oauth2"
+)
+
+// AuthMode selects how tokenFromWeb performs the interactive OAuth 2.0 handshake.
+type AuthMode int
+
+const (
+	// AuthModeBrowser opens the user's browser and listens on an ephemeral local port, as
+	// returned by httptest.NewServer. This is the default and works well on a developer's
+	// own machine.
+	AuthModeBrowser AuthMode = iota
+	// AuthModeManual prints the authorization URL and reads the resulting code back from
+	// stdin, using the out-of-band redirect URI. Use this when the browser and the process
+	// running this code aren't the same machine, e.g. over SSH or inside a container.
+	AuthModeManual
+	// AuthModeLoopback is like AuthModeBrowser but binds a fixed, configurable loopback port
+	// (see --strava-loopback-port) instead of a random one, so it works with a redirect URI
+	// that's been pre-registered with Strava.
+	AuthModeLoopback
+)
+
+func (m AuthMode) String() string {
+	switch m {
+	case AuthModeBrowser:
+		return "browser"
+	case AuthModeManual:
+		return "manual"
+	case AuthModeLoopback:
+		return "loopback"
+	default:
+		return fmt.Sprintf("AuthMode(%d)", int(m))
+	}
+}
+
+func parseAuthMode(s string) (AuthMode, error) {
+	switch s {
+	case "browser":
+		return AuthModeBrowser, nil
+	case "manual":
+		return AuthModeManual, nil
+	case "loopback":
+		return AuthModeLoopback, nil
+	default:
+		return 0, fmt.Errorf("unknown --strava-auth-mode %q: must be browser, manual, or loopback", s)
+	}
+}
+
+// oobRedirectURI is the out-of-band redirect URI: it tells the authorization server to display
+// the code to the user instead of redirecting a browser, which is what AuthModeManual needs.
+const oobRedirectURI = "urn:ietf:wg:oauth:2.0:oob"
+
+// tokenFromWebManual performs the handshake for AuthMode=manual: the auth URL is printed for the
+// user to open on whatever device has a browser, and the resulting code is read back from stdin.
+func tokenFromWebManual(ctx context.Context, config *oauth2.Config) *oauth2.Token {
+	config.RedirectURL = oobRedirectURI
+	req := newAuthCodeRequest()
+	authURL := req.authURL(config)
+	fmt.Printf("Go to the following link in your browser, then paste the resulting code here:\n\n%s\n\nCode: ", authURL)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	if !scanner.Scan() {
+		log.Fatalf("Error reading code from stdin: %v", scanner.Err())
+	}
+	code := scanner.Text()
+
+	return req.exchange(ctx, config, code)
+}
+
+// tokenFromWebLoopback performs the handshake for AuthMode=loopback: identical to
+// AuthMode=browser except the callback server binds a fixed, pre-registered port instead of an
+// ephemeral one.
+func tokenFromWebLoopback(ctx context.Context, config *oauth2.Config) *oauth2.Token {
+	lis, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", *loopbackPort))
+	if err != nil {
+		log.Fatalf("Error binding loopback port %d: %v", *loopbackPort, err)
+	}
+
+	req := newAuthCodeRequest()
+	ch := make(chan string)
+	srv := &http.Server{Handler: callbackHandler(req.state, ch)}
+	go srv.Serve(lis)
+	defer srv.Close()
+
+	config.RedirectURL = fmt.Sprintf("http://127.0.0.1:%d", *loopbackPort)
+	authURL := req.authURL(config)
+	go openURL(authURL)
+	log.Printf("Authorize this app at: %s", authURL)
+	code := <-ch
+	log.Printf("Got code: %s", code)
+
+	return req.exchange(ctx, config, code)
+}
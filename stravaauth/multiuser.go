@@ -0,0 +1,67 @@
+package stravaauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"golang.org/x/oauth2"
+)
+
+// GetOAuth2CtxForUser is GetOAuth2Ctx scoped to a single athlete's cached token, identified by
+// key. Use this when a single machine or process authenticates more than one Strava athlete
+// against the same app, e.g. a server with WithUser(athleteUsername) per incoming request.
+func GetOAuth2CtxForUser(parentCtx context.Context, oauth2ContextType fmt.Stringer, key string, scopes []string, opts ...Option) (context.Context, error) {
+	return GetOAuth2Ctx(parentCtx, oauth2ContextType, scopes, append(opts, WithUser(key))...)
+}
+
+// ListableTokenStore is implemented by TokenStores that can enumerate the keys they hold, which
+// ListCachedUsers needs. The file-based stores in this package implement it; KeyringTokenStore
+// does not, since OS keyrings don't offer a generic "list everything this app stored" API.
+type ListableTokenStore interface {
+	TokenStore
+	List() ([]string, error)
+}
+
+// ListCachedUsers returns the cache keys of every token currently held in the configured
+// TokenStore (the default gob file store, or whatever WithTokenStore option was passed — pass the
+// same one given to LoadTokenSource/GetOAuth2Ctx). It returns an error if that store doesn't
+// implement ListableTokenStore.
+func ListCachedUsers(opts ...Option) ([]string, error) {
+	o := resolveOptions(opts)
+	ls, ok := o.store.(ListableTokenStore)
+	if !ok {
+		return nil, errors.New("configured token store does not support listing cached users")
+	}
+	return ls.List()
+}
+
+// DeleteCachedUser removes the token stored under key from the configured TokenStore (the default
+// gob file store, or whatever WithTokenStore option was passed), e.g. to force an athlete to
+// re-authenticate. key should be one returned by ListCachedUsers.
+func DeleteCachedUser(key string, opts ...Option) error {
+	o := resolveOptions(opts)
+	return o.store.Delete(key)
+}
+
+// AthleteIDFromToken extracts the Strava athlete ID that Strava includes alongside the token in
+// response to the initial authorization code exchange (as an "athlete" object with an "id"
+// field), for use as a UserKey in multi-athlete servers. It returns ok=false if tok doesn't carry
+// that extra field, which is the case for tokens obtained via refresh rather than the original
+// exchange.
+func AthleteIDFromToken(tok *oauth2.Token) (id int64, ok bool) {
+	athlete, ok := tok.Extra("athlete").(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	idFloat, ok := athlete["id"].(float64)
+	if !ok {
+		return 0, false
+	}
+	return int64(idFloat), true
+}
+
+func formatAthleteID(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
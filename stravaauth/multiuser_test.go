@@ -0,0 +1,85 @@
+package stravaauth
+
+import (
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func tokenWithAthleteID(id int64) *oauth2.Token {
+	tok := testToken()
+	return tok.WithExtra(map[string]interface{}{
+		"athlete": map[string]interface{}{"id": float64(id)},
+	})
+}
+
+func TestAthleteIDFromToken(t *testing.T) {
+	id, ok := AthleteIDFromToken(tokenWithAthleteID(12345))
+	if !ok || id != 12345 {
+		t.Errorf("AthleteIDFromToken() = (%d, %v), want (12345, true)", id, ok)
+	}
+
+	if _, ok := AthleteIDFromToken(testToken()); ok {
+		t.Error("AthleteIDFromToken() on a token with no athlete extra = ok, want !ok")
+	}
+}
+
+func TestWithAthleteIDAsUserKey(t *testing.T) {
+	o := resolveOptions([]Option{WithAthleteIDAsUserKey()})
+	if o.deriveUser == nil {
+		t.Fatal("WithAthleteIDAsUserKey did not set deriveUser")
+	}
+	if got, want := o.deriveUser(tokenWithAthleteID(12345)), "12345"; got != want {
+		t.Errorf("deriveUser() = %q, want %q", got, want)
+	}
+	if got := o.deriveUser(testToken()); got != "" {
+		t.Errorf("deriveUser() on a token with no athlete extra = %q, want \"\"", got)
+	}
+}
+
+func TestWithUserFromToken(t *testing.T) {
+	o := resolveOptions([]Option{WithUserFromToken(func(tok *oauth2.Token) string {
+		return "custom:" + tok.AccessToken
+	})})
+	if got, want := o.deriveUser(testToken()), "custom:access"; got != want {
+		t.Errorf("deriveUser() = %q, want %q", got, want)
+	}
+}
+
+func TestListAndDeleteCachedUsers(t *testing.T) {
+	store := NewMemoryTokenStore()
+	if err := store.Put("111", testToken()); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := store.Put("222", testToken()); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	got, err := ListCachedUsers(WithTokenStore(store))
+	if err != nil {
+		t.Fatalf("ListCachedUsers: %v", err)
+	}
+	gotSet := map[string]bool{}
+	for _, k := range got {
+		gotSet[k] = true
+	}
+	if want := (map[string]bool{"111": true, "222": true}); len(gotSet) != len(want) || !gotSet["111"] || !gotSet["222"] {
+		t.Errorf("ListCachedUsers() = %v, want keys %v", got, want)
+	}
+
+	if err := DeleteCachedUser("111", WithTokenStore(store)); err != nil {
+		t.Fatalf("DeleteCachedUser: %v", err)
+	}
+	if _, err := store.Get("111"); err == nil {
+		t.Error("token for \"111\" still present in the configured store after DeleteCachedUser")
+	}
+	if _, err := store.Get("222"); err != nil {
+		t.Errorf("DeleteCachedUser(\"111\") unexpectedly removed \"222\" too: %v", err)
+	}
+}
+
+func TestListCachedUsersUnlistableStore(t *testing.T) {
+	if _, err := ListCachedUsers(WithTokenStore(NewKeyringTokenStore())); err == nil {
+		t.Error("ListCachedUsers with a non-listable store: expected an error, got none")
+	}
+}
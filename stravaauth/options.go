@@ -0,0 +1,61 @@
+package stravaauth
+
+import "golang.org/x/oauth2"
+
+// options holds the settings GetOAuth2Ctx and LoadTokenSource accept as functional Options.
+type options struct {
+	store      TokenStore
+	userKey    string
+	deriveUser func(tok *oauth2.Token) string
+}
+
+func resolveOptions(opts []Option) *options {
+	o := &options{store: defaultTokenStore()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Option configures optional behavior of GetOAuth2Ctx and LoadTokenSource.
+type Option func(*options)
+
+// WithTokenStore overrides where tokens are persisted. The default is a gob-encoded file in the
+// OS user cache dir, matching this package's original behavior; see JSONFileTokenStore,
+// KeyringTokenStore, EncryptedFileTokenStore, SQLiteTokenStore, and MemoryTokenStore for
+// alternatives.
+func WithTokenStore(store TokenStore) Option {
+	return func(o *options) { o.store = store }
+}
+
+// WithUser scopes the cached token to key, so a single machine (and a single TokenStore) can hold
+// tokens for more than one Strava athlete at once. See GetOAuth2CtxForUser, ListCachedUsers, and
+// DeleteCachedUser.
+func WithUser(key string) Option {
+	return func(o *options) { o.userKey = key }
+}
+
+// WithUserFromToken scopes the cached token to whatever key deriveKey returns, computed from the
+// token obtained by the initial browser login. This is useful when the user identifier isn't
+// known up front, e.g. deriving it from the athlete profile Strava returns alongside the token
+// (see AthleteIDFromToken and WithAthleteIDAsUserKey). It has no effect on a cache hit, since the
+// key has to be known before the store can be queried, and it is ignored when WithUser is also
+// given. A token saved under a derived key is found again only by passing that key to WithUser
+// (or GetOAuth2CtxForUser); a later call without it looks up the shared default key instead.
+func WithUserFromToken(deriveKey func(tok *oauth2.Token) string) Option {
+	return func(o *options) { o.deriveUser = deriveKey }
+}
+
+// WithAthleteIDAsUserKey is a convenience for WithUserFromToken that uses the Strava athlete ID
+// returned alongside a fresh token exchange (see AthleteIDFromToken) as the UserKey, formatted as
+// a decimal string. Pair it with a TokenStore like SQLiteTokenStore so a server authenticating
+// many athletes can look tokens back up by athlete ID.
+func WithAthleteIDAsUserKey() Option {
+	return WithUserFromToken(func(tok *oauth2.Token) string {
+		id, ok := AthleteIDFromToken(tok)
+		if !ok {
+			return ""
+		}
+		return formatAthleteID(id)
+	})
+}
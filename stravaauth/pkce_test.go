@@ -0,0 +1,64 @@
+package stravaauth
+
+import (
+	"encoding/base64"
+	"sync"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestNewCodeVerifier(t *testing.T) {
+	v1, err := newCodeVerifier()
+	if err != nil {
+		t.Fatalf("newCodeVerifier: %v", err)
+	}
+	if _, err := base64.RawURLEncoding.DecodeString(v1); err != nil {
+		t.Errorf("verifier %q is not valid unpadded base64url: %v", v1, err)
+	}
+	v2, err := newCodeVerifier()
+	if err != nil {
+		t.Fatalf("newCodeVerifier: %v", err)
+	}
+	if v1 == v2 {
+		t.Errorf("two calls to newCodeVerifier returned the same value %q", v1)
+	}
+}
+
+func TestCodeChallengeS256(t *testing.T) {
+	// Test vector from https://datatracker.ietf.org/doc/html/rfc7636#appendix-B.
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	const want = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if got := codeChallengeS256(verifier); got != want {
+		t.Errorf("codeChallengeS256(%q) = %q, want %q", verifier, got, want)
+	}
+}
+
+// fakeTokenSource always returns the same token, so the race detector is exercising
+// persistentTokenSource's own locking rather than any in the base source.
+type fakeTokenSource struct{}
+
+func (fakeTokenSource) Token() (*oauth2.Token, error) {
+	return &oauth2.Token{AccessToken: "tok"}, nil
+}
+
+func TestPersistentTokenSourceConcurrentToken(t *testing.T) {
+	store := NewMemoryTokenStore()
+	pts := newPersistentTokenSource(fakeTokenSource{}, store, "key", nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pts.Token(); err != nil {
+				t.Errorf("Token: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := store.Get("key"); err != nil {
+		t.Errorf("expected a token to have been cached under %q: %v", "key", err)
+	}
+}
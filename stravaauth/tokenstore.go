@@ -0,0 +1,331 @@
+package stravaauth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/oauth2"
+)
+
+// TokenStore persists oauth2.Tokens keyed by an opaque string (see tokenCacheKey), so that
+// GetOAuth2Ctx and LoadTokenSource don't need to know how or where a token ends up stored.
+type TokenStore interface {
+	// Get returns the token previously Put under key, or an error if none is stored.
+	Get(key string) (*oauth2.Token, error)
+	// Put stores tok under key, overwriting whatever was stored there before.
+	Put(key string, tok *oauth2.Token) error
+	// Delete removes whatever is stored under key. It is not an error if nothing was stored.
+	Delete(key string) error
+}
+
+// defaultTokenStore is the TokenStore used when no WithTokenStore option is supplied. It matches
+// the on-disk format this package has always used, for compatibility with existing caches.
+func defaultTokenStore() TokenStore {
+	return &gobFileTokenStore{dir: osUserCacheDir()}
+}
+
+// gobFileTokenStore stores each token as a gob-encoded file in dir, named after its key. This is
+// the original cache format: world-readable-within-the-user's-cache-dir and unencrypted, kept
+// around for backwards compatibility and as the zero-config default.
+type gobFileTokenStore struct {
+	dir string
+}
+
+func (s *gobFileTokenStore) path(key string) string {
+	return filepath.Join(s.dir, key)
+}
+
+func (s *gobFileTokenStore) Get(key string) (*oauth2.Token, error) {
+	if !*cacheToken {
+		return nil, errors.New("--strava-cachetoken is false")
+	}
+	f, err := os.Open(s.path(key))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t := new(oauth2.Token)
+	if err := gob.NewDecoder(f).Decode(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *gobFileTokenStore) Put(key string, tok *oauth2.Token) error {
+	f, err := os.Create(s.path(key))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return gob.NewEncoder(f).Encode(tok)
+}
+
+func (s *gobFileTokenStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *gobFileTokenStore) List() ([]string, error) {
+	return listDir(s.dir, func(name string) (string, bool) {
+		if !strings.HasPrefix(name, tokenFilePrefix) {
+			return "", false
+		}
+		return name, true
+	})
+}
+
+// JSONFileTokenStore stores each token as a JSON file in dir, named after its key. Prefer this
+// over the gob default when you want the cache to be human-readable or inspectable by tools
+// outside this package.
+type JSONFileTokenStore struct {
+	Dir string
+}
+
+// NewJSONFileTokenStore returns a TokenStore that writes one JSON file per key into dir.
+func NewJSONFileTokenStore(dir string) *JSONFileTokenStore {
+	return &JSONFileTokenStore{Dir: dir}
+}
+
+func (s *JSONFileTokenStore) path(key string) string {
+	return filepath.Join(s.Dir, key+".json")
+}
+
+func (s *JSONFileTokenStore) Get(key string) (*oauth2.Token, error) {
+	b, err := ioutil.ReadFile(s.path(key))
+	if err != nil {
+		return nil, err
+	}
+	t := new(oauth2.Token)
+	if err := json.Unmarshal(b, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *JSONFileTokenStore) Put(key string, tok *oauth2.Token) error {
+	b, err := json.MarshalIndent(tok, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path(key), b, 0600)
+}
+
+func (s *JSONFileTokenStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *JSONFileTokenStore) List() ([]string, error) {
+	return listDir(s.Dir, func(name string) (string, bool) {
+		key := strings.TrimSuffix(name, ".json")
+		return key, key != name
+	})
+}
+
+// keyringService is the service name this package registers tokens under in the OS keyring.
+const keyringService = "strava-oauth-helper"
+
+// KeyringTokenStore stores each token as a JSON-encoded secret in the OS's native credential
+// store: Keychain on macOS, Credential Manager on Windows, or a Secret Service (e.g. libsecret)
+// implementation on Linux. Unlike the file-based stores, tokens never touch disk in the clear.
+type KeyringTokenStore struct{}
+
+// NewKeyringTokenStore returns a TokenStore backed by the OS keyring.
+func NewKeyringTokenStore() *KeyringTokenStore {
+	return &KeyringTokenStore{}
+}
+
+func (s *KeyringTokenStore) Get(key string) (*oauth2.Token, error) {
+	secret, err := keyring.Get(keyringService, key)
+	if err != nil {
+		return nil, err
+	}
+	t := new(oauth2.Token)
+	if err := json.Unmarshal([]byte(secret), t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *KeyringTokenStore) Put(key string, tok *oauth2.Token) error {
+	b, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	return keyring.Set(keyringService, key, string(b))
+}
+
+func (s *KeyringTokenStore) Delete(key string) error {
+	err := keyring.Delete(keyringService, key)
+	if err == keyring.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// EncryptedFileTokenStore stores each token as an AES-256-GCM encrypted file in Dir, keyed by a
+// passphrase. The passphrase is stretched into a 32-byte key with SHA-256, which is adequate here
+// because the passphrase is expected to come from a high-entropy source (e.g. a secrets manager)
+// rather than being typed by a human; it is not a replacement for a proper password-hashing KDF.
+type EncryptedFileTokenStore struct {
+	Dir        string
+	Passphrase string
+}
+
+// NewEncryptedFileTokenStore returns a TokenStore that AES-GCM-encrypts each token file in dir
+// using a key derived from passphrase.
+func NewEncryptedFileTokenStore(dir, passphrase string) *EncryptedFileTokenStore {
+	return &EncryptedFileTokenStore{Dir: dir, Passphrase: passphrase}
+}
+
+func (s *EncryptedFileTokenStore) path(key string) string {
+	return filepath.Join(s.Dir, key+".enc")
+}
+
+func (s *EncryptedFileTokenStore) gcm() (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(s.Passphrase))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *EncryptedFileTokenStore) Get(key string) (*oauth2.Token, error) {
+	ciphertext, err := ioutil.ReadFile(s.path(key))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := s.gcm()
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("encrypted token file is corrupt")
+	}
+	nonce, encrypted := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, encrypted, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting token: %w", err)
+	}
+	t := new(oauth2.Token)
+	if err := json.Unmarshal(plaintext, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *EncryptedFileTokenStore) Put(key string, tok *oauth2.Token) error {
+	plaintext, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	gcm, err := s.gcm()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
+	return ioutil.WriteFile(s.path(key), ciphertext, 0600)
+}
+
+func (s *EncryptedFileTokenStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *EncryptedFileTokenStore) List() ([]string, error) {
+	return listDir(s.Dir, func(name string) (string, bool) {
+		key := strings.TrimSuffix(name, ".enc")
+		return key, key != name
+	})
+}
+
+// MemoryTokenStore is an in-memory TokenStore, useful in tests so they don't touch disk or the
+// OS keyring.
+type MemoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]*oauth2.Token
+}
+
+// NewMemoryTokenStore returns an empty in-memory TokenStore.
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: make(map[string]*oauth2.Token)}
+}
+
+func (s *MemoryTokenStore) Get(key string) (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tok, ok := s.tokens[key]
+	if !ok {
+		return nil, fmt.Errorf("no token stored for key %q", key)
+	}
+	return tok, nil
+}
+
+func (s *MemoryTokenStore) Put(key string, tok *oauth2.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[key] = tok
+	return nil
+}
+
+func (s *MemoryTokenStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, key)
+	return nil
+}
+
+func (s *MemoryTokenStore) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.tokens))
+	for k := range s.tokens {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// listDir lists the entries of dir, passing each file name through keep, which returns the
+// derived cache key and whether to include it.
+func listDir(dir string, keep func(name string) (key string, ok bool)) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if key, ok := keep(e.Name()); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,87 @@
+package stravaauth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"golang.org/x/oauth2"
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteTokenStore persists tokens in a SQLite database, one row per key. It's intended for
+// servers that authenticate many Strava athletes at once, keyed by athlete ID (see
+// AthleteIDFromToken and WithAthleteIDAsUserKey), where a directory of individual token files
+// would be unwieldy.
+type SQLiteTokenStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteTokenStore opens (creating if necessary) a SQLite database at path and returns a
+// TokenStore backed by it. Callers should Close it when done.
+func NewSQLiteTokenStore(path string) (*SQLiteTokenStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS tokens (
+		key   TEXT PRIMARY KEY,
+		token TEXT NOT NULL
+	)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initializing token store schema: %w", err)
+	}
+	return &SQLiteTokenStore{db: db}, nil
+}
+
+// Close releases the underlying database connection.
+func (s *SQLiteTokenStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteTokenStore) Get(key string) (*oauth2.Token, error) {
+	var raw string
+	err := s.db.QueryRow(`SELECT token FROM tokens WHERE key = ?`, key).Scan(&raw)
+	if err != nil {
+		return nil, err
+	}
+	t := new(oauth2.Token)
+	if err := json.Unmarshal([]byte(raw), t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *SQLiteTokenStore) Put(key string, tok *oauth2.Token) error {
+	raw, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO tokens (key, token) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET token = excluded.token`, key, string(raw))
+	return err
+}
+
+func (s *SQLiteTokenStore) Delete(key string) error {
+	_, err := s.db.Exec(`DELETE FROM tokens WHERE key = ?`, key)
+	return err
+}
+
+// List returns the keys of every token currently stored. Tokens saved by this package are keyed
+// by tokenCacheKey, a hash that includes the user key (e.g. the athlete ID), not the raw user key.
+func (s *SQLiteTokenStore) List() ([]string, error) {
+	rows, err := s.db.Query(`SELECT key FROM tokens`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
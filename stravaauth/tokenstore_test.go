@@ -0,0 +1,155 @@
+package stravaauth
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func testToken() *oauth2.Token {
+	return (&oauth2.Token{
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		TokenType:    "Bearer",
+		Expiry:       time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+}
+
+func testTokenStoreRoundTrip(t *testing.T, store TokenStore) {
+	t.Helper()
+	const key = "athlete-1"
+
+	if _, err := store.Get(key); err == nil {
+		t.Fatalf("Get before Put: expected an error, got none")
+	}
+
+	want := testToken()
+	if err := store.Put(key, want); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	got, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Get after Put: %v", err)
+	}
+	if got.AccessToken != want.AccessToken || got.RefreshToken != want.RefreshToken || !got.Expiry.Equal(want.Expiry) {
+		t.Errorf("Get after Put = %#v, want %#v", got, want)
+	}
+
+	if err := store.Delete(key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Get(key); err == nil {
+		t.Errorf("Get after Delete: expected an error, got none")
+	}
+	// Deleting an already-absent key should still succeed.
+	if err := store.Delete(key); err != nil {
+		t.Errorf("Delete of an already-deleted key: %v", err)
+	}
+}
+
+func TestGobFileTokenStore(t *testing.T) {
+	testTokenStoreRoundTrip(t, &gobFileTokenStore{dir: t.TempDir()})
+}
+
+func TestJSONFileTokenStore(t *testing.T) {
+	testTokenStoreRoundTrip(t, NewJSONFileTokenStore(t.TempDir()))
+}
+
+func TestEncryptedFileTokenStore(t *testing.T) {
+	testTokenStoreRoundTrip(t, NewEncryptedFileTokenStore(t.TempDir(), "correct horse battery staple"))
+}
+
+func TestEncryptedFileTokenStoreWrongPassphraseFails(t *testing.T) {
+	dir := t.TempDir()
+	store := NewEncryptedFileTokenStore(dir, "right passphrase")
+	if err := store.Put("key", testToken()); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	wrongStore := NewEncryptedFileTokenStore(dir, "wrong passphrase")
+	if _, err := wrongStore.Get("key"); err == nil {
+		t.Error("Get with the wrong passphrase: expected an error, got none")
+	}
+}
+
+func TestSQLiteTokenStore(t *testing.T) {
+	store, err := NewSQLiteTokenStore(filepath.Join(t.TempDir(), "tokens.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteTokenStore: %v", err)
+	}
+	defer store.Close()
+	testTokenStoreRoundTrip(t, store)
+}
+
+func TestSQLiteTokenStoreList(t *testing.T) {
+	store, err := NewSQLiteTokenStore(filepath.Join(t.TempDir(), "tokens.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteTokenStore: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Put("111", testToken()); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := store.Put("222", testToken()); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	got, err := store.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	want := map[string]bool{"111": true, "222": true}
+	gotSet := map[string]bool{}
+	for _, k := range got {
+		gotSet[k] = true
+	}
+	if !reflect.DeepEqual(gotSet, want) {
+		t.Errorf("List() = %v, want keys %v", got, want)
+	}
+}
+
+func TestMemoryTokenStore(t *testing.T) {
+	testTokenStoreRoundTrip(t, NewMemoryTokenStore())
+}
+
+func TestMemoryTokenStoreList(t *testing.T) {
+	store := NewMemoryTokenStore()
+	if err := store.Put("a", testToken()); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := store.Put("b", testToken()); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	got, err := store.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	want := map[string]bool{"a": true, "b": true}
+	gotSet := map[string]bool{}
+	for _, k := range got {
+		gotSet[k] = true
+	}
+	if !reflect.DeepEqual(gotSet, want) {
+		t.Errorf("List() = %v, want keys %v", got, want)
+	}
+}
+
+func TestKeyringTokenStore(t *testing.T) {
+	store := NewKeyringTokenStore()
+	if err := store.Put("keyring-test-key", testToken()); err != nil {
+		t.Skipf("OS keyring unavailable in this environment: %v", err)
+	}
+	defer store.Delete("keyring-test-key")
+
+	got, err := store.Get("keyring-test-key")
+	if err != nil {
+		t.Fatalf("Get after Put: %v", err)
+	}
+	if got.AccessToken != "access" {
+		t.Errorf("Get().AccessToken = %q, want %q", got.AccessToken, "access")
+	}
+}
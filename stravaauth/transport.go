@@ -0,0 +1,228 @@
+package stravaauth
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+var (
+	refreshWindow = flag.Duration("strava-refresh-window", 5*time.Minute,
+		"Proactively refresh the OAuth 2.0 token once it's within this long of expiring, instead of waiting for a request to fail.")
+	maxRetries = flag.Int("strava-max-retries", 3,
+		"How many times to retry a Strava API request that comes back 429 or 5xx before giving up.")
+)
+
+// GetOAuth2Client returns an *http.Client authorized for the given scopes, performing the same
+// login/caching dance as LoadTokenSource. Unlike the context returned by GetOAuth2Ctx, the
+// client's Transport proactively refreshes the token before it expires (see
+// --strava-refresh-window), paces requests against Strava's X-RateLimit-Limit /
+// X-RateLimit-Usage response headers, and retries 429/5xx responses with exponential backoff
+// honoring any Retry-After header. Use this for plain net/http callers; swagger-generated clients
+// should keep using GetOAuth2Ctx.
+func GetOAuth2Client(parentCtx context.Context, scopes []string, opts ...Option) (*http.Client, error) {
+	if !flag.Parsed() {
+		return nil, errOAuth2NotParsed
+	}
+	o := resolveOptions(opts)
+	config, tok, cacheKey := configAndToken(parentCtx, scopes, o)
+	managedTS := newManagedTokenSource(parentCtx, config, o.store, cacheKey, tok, *refreshWindow)
+	return &http.Client{
+		Transport: &oauth2.Transport{
+			Source: managedTS,
+			Base:   newRateLimitTransport(http.DefaultTransport, *maxRetries),
+		},
+	}, nil
+}
+
+// managedTokenSource is an oauth2.TokenSource that proactively refreshes the token once it's
+// within refreshWindow of expiring (rather than only once it's actually expired, which is all the
+// stock oauth2.TokenSource does), and persists refreshed tokens back to store.
+type managedTokenSource struct {
+	ctx      context.Context
+	config   *oauth2.Config
+	store    TokenStore
+	cacheKey string
+	window   time.Duration
+
+	mu  sync.Mutex
+	cur *oauth2.Token
+}
+
+func newManagedTokenSource(ctx context.Context, config *oauth2.Config, store TokenStore, cacheKey string, initial *oauth2.Token, window time.Duration) *managedTokenSource {
+	return &managedTokenSource{ctx: ctx, config: config, store: store, cacheKey: cacheKey, window: window, cur: initial}
+}
+
+func (m *managedTokenSource) Token() (*oauth2.Token, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cur.Valid() && time.Until(m.cur.Expiry) > m.window {
+		return m.cur, nil
+	}
+
+	// Either the token is already expired, or it's inside the refresh window: force a refresh
+	// now by handing oauth2 a copy that looks expired, rather than waiting for it to actually
+	// expire.
+	forced := *m.cur
+	if !forced.Expiry.IsZero() {
+		forced.Expiry = time.Now().Add(-time.Minute)
+	}
+	tok, err := m.config.TokenSource(m.ctx, &forced).Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok.AccessToken != m.cur.AccessToken || tok.RefreshToken != m.cur.RefreshToken {
+		if err := m.store.Put(m.cacheKey, tok); err != nil {
+			log.Printf("Warning: failed to cache refreshed oauth token: %v", err)
+		} else {
+			log.Printf("Proactively refreshed and saved token %#v under key %q", tok, m.cacheKey)
+		}
+	}
+	m.cur = tok
+	return tok, nil
+}
+
+// rateLimitTransport wraps base to stay under Strava's API rate limits and ride out transient
+// failures. Strava enforces both a 15-minute and a daily quota; it reports current usage against
+// both on every response via the X-RateLimit-Limit / X-RateLimit-Usage headers (each a
+// "short,daily" pair), so callers can back off before actually being throttled.
+type rateLimitTransport struct {
+	base       http.RoundTripper
+	maxRetries int
+
+	mu         sync.Mutex
+	shortLimit int
+	shortUsage int
+	dailyLimit int
+	dailyUsage int
+}
+
+func newRateLimitTransport(base http.RoundTripper, maxRetries int) *rateLimitTransport {
+	return &rateLimitTransport{base: base, maxRetries: maxRetries}
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if wait := t.waitForCapacity(); wait > 0 {
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+
+	backoff := time.Second
+	var resp *http.Response
+	var err error
+	for attempt := 0; ; attempt++ {
+		resp, err = t.base.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		t.recordUsage(resp.Header)
+
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			return resp, nil
+		}
+		if attempt >= t.maxRetries {
+			return resp, nil
+		}
+
+		wait := retryAfter(resp.Header)
+		if wait == 0 {
+			wait = backoff
+			backoff *= 2
+		}
+		resp.Body.Close()
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// waitForCapacity returns how long to sleep, if at all, before the next request is likely to be
+// accepted rather than throttled, based on the usage last reported by Strava.
+func (t *rateLimitTransport) waitForCapacity() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now().UTC()
+	if t.shortLimit > 0 && t.shortUsage >= t.shortLimit {
+		return nextQuarterHour(now).Sub(now)
+	}
+	if t.dailyLimit > 0 && t.dailyUsage >= t.dailyLimit {
+		return nextMidnightUTC(now).Sub(now)
+	}
+	return 0
+}
+
+func (t *rateLimitTransport) recordUsage(h http.Header) {
+	shortLimit, dailyLimit, ok := parseRateLimitPair(h.Get("X-RateLimit-Limit"))
+	if !ok {
+		return
+	}
+	shortUsage, dailyUsage, ok := parseRateLimitPair(h.Get("X-RateLimit-Usage"))
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.shortLimit, t.dailyLimit = shortLimit, dailyLimit
+	t.shortUsage, t.dailyUsage = shortUsage, dailyUsage
+}
+
+// parseRateLimitPair parses Strava's "short,daily" header value format, used by both
+// X-RateLimit-Limit and X-RateLimit-Usage.
+func parseRateLimitPair(v string) (short, daily int, ok bool) {
+	parts := strings.SplitN(v, ",", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	short, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
+	daily, err2 := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err1 != nil || err2 != nil {
+		return 0, 0, false
+	}
+	return short, daily, true
+}
+
+// retryAfter parses a Retry-After header (either seconds or an HTTP-date), returning 0 if absent
+// or unparseable.
+func retryAfter(h http.Header) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func nextQuarterHour(now time.Time) time.Time {
+	next := now.Truncate(15 * time.Minute).Add(15 * time.Minute)
+	return next
+}
+
+func nextMidnightUTC(now time.Time) time.Time {
+	y, m, d := now.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
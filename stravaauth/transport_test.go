@@ -0,0 +1,211 @@
+package stravaauth
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestParseRateLimitPair(t *testing.T) {
+	tests := []struct {
+		in        string
+		wantShort int
+		wantDaily int
+		wantOK    bool
+	}{
+		{"100,1000", 100, 1000, true},
+		{"100, 1000", 100, 1000, true},
+		{"", 0, 0, false},
+		{"100", 0, 0, false},
+		{"abc,1000", 0, 0, false},
+		{"100,abc", 0, 0, false},
+	}
+	for _, tt := range tests {
+		short, daily, ok := parseRateLimitPair(tt.in)
+		if short != tt.wantShort || daily != tt.wantDaily || ok != tt.wantOK {
+			t.Errorf("parseRateLimitPair(%q) = (%d, %d, %v), want (%d, %d, %v)",
+				tt.in, short, daily, ok, tt.wantShort, tt.wantDaily, tt.wantOK)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	h := http.Header{}
+	if got := retryAfter(h); got != 0 {
+		t.Errorf("retryAfter with no header = %v, want 0", got)
+	}
+
+	h.Set("Retry-After", "30")
+	if got := retryAfter(h); got != 30*time.Second {
+		t.Errorf("retryAfter(30) = %v, want 30s", got)
+	}
+
+	h.Set("Retry-After", "not-a-date-or-int")
+	if got := retryAfter(h); got != 0 {
+		t.Errorf("retryAfter(garbage) = %v, want 0", got)
+	}
+
+	future := time.Now().Add(time.Hour).UTC()
+	h.Set("Retry-After", future.Format(http.TimeFormat))
+	got := retryAfter(h)
+	if got <= 0 || got > time.Hour {
+		t.Errorf("retryAfter(HTTP-date one hour out) = %v, want a positive duration close to 1h", got)
+	}
+}
+
+func TestNextQuarterHour(t *testing.T) {
+	tests := []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 10, 15, 0, 0, time.UTC)},
+		{time.Date(2024, 1, 1, 10, 7, 30, 0, time.UTC), time.Date(2024, 1, 1, 10, 15, 0, 0, time.UTC)},
+		{time.Date(2024, 1, 1, 10, 14, 59, 0, time.UTC), time.Date(2024, 1, 1, 10, 15, 0, 0, time.UTC)},
+		{time.Date(2024, 1, 1, 10, 59, 0, 0, time.UTC), time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := nextQuarterHour(tt.now); !got.Equal(tt.want) {
+			t.Errorf("nextQuarterHour(%v) = %v, want %v", tt.now, got, tt.want)
+		}
+	}
+}
+
+// stepRoundTripper returns its canned responses in order, one per call.
+type stepRoundTripper struct {
+	responses []*http.Response
+	calls     int
+}
+
+func (s *stepRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp := s.responses[s.calls]
+	s.calls++
+	return resp, nil
+}
+
+func fakeResponse(status int, header http.Header) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{StatusCode: status, Header: header, Body: ioutil.NopCloser(strings.NewReader(""))}
+}
+
+func TestRateLimitTransportRetriesOn429(t *testing.T) {
+	base := &stepRoundTripper{responses: []*http.Response{
+		fakeResponse(http.StatusTooManyRequests, http.Header{"Retry-After": {"0"}}),
+		fakeResponse(http.StatusOK, nil),
+	}}
+	transport := newRateLimitTransport(base, 3)
+
+	resp, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "http://example.com", nil))
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("final status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if base.calls != 2 {
+		t.Errorf("base RoundTrip was called %d times, want 2 (one 429, one retry)", base.calls)
+	}
+}
+
+func TestRateLimitTransportGivesUpAfterMaxRetries(t *testing.T) {
+	base := &stepRoundTripper{responses: []*http.Response{
+		fakeResponse(http.StatusTooManyRequests, http.Header{"Retry-After": {"0"}}),
+		fakeResponse(http.StatusTooManyRequests, http.Header{"Retry-After": {"0"}}),
+	}}
+	transport := newRateLimitTransport(base, 1)
+
+	resp, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "http://example.com", nil))
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("final status = %d, want %d (maxRetries exhausted)", resp.StatusCode, http.StatusTooManyRequests)
+	}
+	if base.calls != 2 {
+		t.Errorf("base RoundTrip was called %d times, want 2 (initial attempt + 1 retry)", base.calls)
+	}
+}
+
+func tokenHandler(accessToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  accessToken,
+			"refresh_token": "new-refresh",
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+		})
+	}
+}
+
+func TestManagedTokenSourceRefreshesWithinWindow(t *testing.T) {
+	srv := httptest.NewServer(tokenHandler("new-access"))
+	defer srv.Close()
+
+	config := &oauth2.Config{
+		ClientID:     "id",
+		ClientSecret: "secret",
+		Endpoint:     oauth2.Endpoint{TokenURL: srv.URL},
+	}
+	store := NewMemoryTokenStore()
+	initial := &oauth2.Token{AccessToken: "old-access", RefreshToken: "old-refresh", Expiry: time.Now().Add(2 * time.Minute)}
+	mts := newManagedTokenSource(context.Background(), config, store, "key", initial, 5*time.Minute)
+
+	tok, err := mts.Token()
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if tok.AccessToken != "new-access" {
+		t.Errorf("Token().AccessToken = %q, want %q (should have proactively refreshed)", tok.AccessToken, "new-access")
+	}
+
+	cached, err := store.Get("key")
+	if err != nil {
+		t.Fatalf("expected the refreshed token to be cached: %v", err)
+	}
+	if cached.AccessToken != "new-access" {
+		t.Errorf("cached token = %#v, want AccessToken %q", cached, "new-access")
+	}
+}
+
+func TestManagedTokenSourceSkipsRefreshWhenFresh(t *testing.T) {
+	config := &oauth2.Config{ClientID: "id", ClientSecret: "secret"}
+	store := NewMemoryTokenStore()
+	initial := &oauth2.Token{AccessToken: "still-fresh", Expiry: time.Now().Add(time.Hour)}
+	mts := newManagedTokenSource(context.Background(), config, store, "key", initial, 5*time.Minute)
+
+	tok, err := mts.Token()
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if tok.AccessToken != "still-fresh" {
+		t.Errorf("Token().AccessToken = %q, want %q (should not have refreshed)", tok.AccessToken, "still-fresh")
+	}
+	if _, err := store.Get("key"); err == nil {
+		t.Error("store was written to even though the token was still fresh")
+	}
+}
+
+func TestNextMidnightUTC(t *testing.T) {
+	tests := []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 1, 1, 23, 59, 59, 0, time.UTC), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 12, 31, 12, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := nextMidnightUTC(tt.now); !got.Equal(tt.want) {
+			t.Errorf("nextMidnightUTC(%v) = %v, want %v", tt.now, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Package webhooks manages Strava's Push Subscription API: creating, listing, and deleting
+// webhook subscriptions, and handling the resulting callback requests. It builds on the same
+// client ID and secret stravaauth uses for the OAuth 2.0 handshake (see stravaauth.ClientCredentials),
+// since Strava's subscription endpoints are authenticated with app-level credentials rather than
+// an athlete's access token.
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/srabraham/strava-oauth-helper/stravaauth"
+)
+
+const subscriptionsURL = "https://www.strava.com/api/v3/push_subscriptions"
+
+// Subscription describes a Strava push subscription, as returned by CreateSubscription and
+// ListSubscriptions.
+type Subscription struct {
+	ID            int64  `json:"id"`
+	ApplicationID int64  `json:"application_id"`
+	CallbackURL   string `json:"callback_url"`
+	CreatedAt     string `json:"created_at"`
+	UpdatedAt     string `json:"updated_at"`
+}
+
+// Event is a single webhook event delivered by Strava to the callback handler returned by
+// NewCallbackHandler.
+type Event struct {
+	ObjectType     string            `json:"object_type"`
+	ObjectID       int64             `json:"object_id"`
+	AspectType     string            `json:"aspect_type"`
+	OwnerID        int64             `json:"owner_id"`
+	SubscriptionID int64             `json:"subscription_id"`
+	EventTime      int64             `json:"event_time"`
+	Updates        map[string]string `json:"updates"`
+}
+
+// CreateSubscription registers callbackURL with Strava as a push subscription endpoint. Strava
+// will immediately GET callbackURL to verify it, echoing verifyToken, before this call returns
+// successfully, so the handler from NewCallbackHandler must already be serving callbackURL.
+func CreateSubscription(ctx context.Context, callbackURL, verifyToken string) (*Subscription, error) {
+	clientID, clientSecret := stravaauth.ClientCredentials()
+	form := url.Values{
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+		"callback_url":  {callbackURL},
+		"verify_token":  {verifyToken},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscriptionsURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var sub Subscription
+	if err := doJSON(req, &sub); err != nil {
+		return nil, fmt.Errorf("creating push subscription: %w", err)
+	}
+	return &sub, nil
+}
+
+// ListSubscriptions returns the push subscriptions currently registered for this app. Strava only
+// allows one at a time, but the API returns a list.
+func ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	clientID, clientSecret := stravaauth.ClientCredentials()
+	u := subscriptionsURL + "?" + url.Values{
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+	}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	var subs []Subscription
+	if err := doJSON(req, &subs); err != nil {
+		return nil, fmt.Errorf("listing push subscriptions: %w", err)
+	}
+	return subs, nil
+}
+
+// DeleteSubscription removes the push subscription with the given id.
+func DeleteSubscription(ctx context.Context, id int64) error {
+	clientID, clientSecret := stravaauth.ClientCredentials()
+	u := fmt.Sprintf("%s/%d?%s", subscriptionsURL, id, url.Values{
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+	}.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("deleting push subscription %d: %w", id, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("deleting push subscription %d: %s: %s", id, resp.Status, body)
+	}
+	return nil
+}
+
+func doJSON(req *http.Request, out interface{}) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// NewCallbackHandler returns an http.Handler implementing both sides of Strava's webhook
+// callback: it answers the GET verification challenge Strava sends when CreateSubscription is
+// called, and decodes POST event payloads and passes each one to onEvent.
+func NewCallbackHandler(verifyToken string, onEvent func(Event)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			handleVerification(w, req, verifyToken)
+		case http.MethodPost:
+			handleEvent(w, req, onEvent)
+		default:
+			http.Error(w, "", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func handleVerification(w http.ResponseWriter, req *http.Request, verifyToken string) {
+	q := req.URL.Query()
+	if q.Get("hub.mode") != "subscribe" || q.Get("hub.verify_token") != verifyToken {
+		http.Error(w, "", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"hub.challenge": q.Get("hub.challenge")})
+}
+
+func handleEvent(w http.ResponseWriter, req *http.Request, onEvent func(Event)) {
+	var evt Event
+	if err := json.NewDecoder(req.Body).Decode(&evt); err != nil {
+		http.Error(w, fmt.Sprintf("decoding event: %v", err), http.StatusBadRequest)
+		return
+	}
+	onEvent(evt)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,96 @@
+package webhooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleVerification(t *testing.T) {
+	handler := NewCallbackHandler("secret-token", func(Event) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/?hub.mode=subscribe&hub.verify_token=secret-token&hub.challenge=abc123", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding response body: %v", err)
+	}
+	if got["hub.challenge"] != "abc123" {
+		t.Errorf("hub.challenge = %q, want %q", got["hub.challenge"], "abc123")
+	}
+}
+
+func TestHandleVerificationWrongToken(t *testing.T) {
+	handler := NewCallbackHandler("secret-token", func(Event) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/?hub.mode=subscribe&hub.verify_token=wrong&hub.challenge=abc123", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestHandleEvent(t *testing.T) {
+	var got Event
+	handler := NewCallbackHandler("secret-token", func(evt Event) { got = evt })
+
+	body, err := json.Marshal(Event{
+		ObjectType:     "activity",
+		ObjectID:       12345,
+		AspectType:     "create",
+		OwnerID:        67890,
+		SubscriptionID: 1,
+		EventTime:      1609459200,
+	})
+	if err != nil {
+		t.Fatalf("marshaling event: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got.ObjectType != "activity" || got.ObjectID != 12345 || got.AspectType != "create" {
+		t.Errorf("onEvent received %#v, want matching the posted body", got)
+	}
+}
+
+func TestHandleEventInvalidJSON(t *testing.T) {
+	called := false
+	handler := NewCallbackHandler("secret-token", func(Event) { called = true })
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte("not json")))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if called {
+		t.Errorf("onEvent was called with an invalid payload")
+	}
+}
+
+func TestCallbackHandlerMethodNotAllowed(t *testing.T) {
+	handler := NewCallbackHandler("secret-token", func(Event) {})
+
+	req := httptest.NewRequest(http.MethodPut, "/", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}